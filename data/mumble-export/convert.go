@@ -0,0 +1,104 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import dto "github.com/prometheus/client_model/go"
+
+// span mirrors prompb/writev2's BucketSpan: a run of Length buckets starting
+// Offset buckets after the previous run (or after bucket 0 for the first).
+type span struct {
+	Offset int32
+	Length uint32
+}
+
+// resetHint mirrors the RW1/RW2 histogram reset hint enum. monitoring_data
+// holds both cumulative, counter-style histograms (ingested straight off a
+// CSV/prom/openmetrics/remote-write source) and gauge-style snapshots
+// (produced by nhmerge.Merge); mumble-export doesn't track resets across
+// samples, so it reports "gauge" either way, which just tells the receiver
+// not to assume monotonicity between points.
+type resetHint int32
+
+const resetHintGauge resetHint = 3 // matches prompb.Histogram_GAUGE / writev2.Histogram_RESET_HINT_GAUGE
+
+// histogramFields are the wire-format-agnostic pieces of a dto.Histogram,
+// shared by the Remote Write 1.0 and 2.0 encoders so the span/delta/zero
+// bucket mapping logic only needs to be gotten right once.
+//
+// dto.Histogram encodes Count and ZeroCount as an int-or-float oneof, and
+// the bucket spans as either cumulative deltas or absolute counts; only one
+// side of each pair is ever populated on a given histogram, recorded here by
+// countIsFloat/zeroCountIsFloat/isDelta so the encoders can reproduce
+// whichever encoding the source histogram actually used instead of assuming
+// one.
+type histogramFields struct {
+	schema           int32
+	zeroThreshold    float64
+	zeroCount        uint64
+	zeroCountFloat   float64
+	zeroCountIsFloat bool
+	count            uint64
+	countFloat       float64
+	countIsFloat     bool
+	sum              float64
+	positiveSpans    []span
+	positiveDeltas   []int64
+	positiveCounts   []float64
+	negativeSpans    []span
+	negativeDeltas   []int64
+	negativeCounts   []float64
+	isDelta          bool
+	resetHint        resetHint
+}
+
+// extractFields reads h into the wire-agnostic shape used by both encoders,
+// preserving which int/float and delta/count encoding h actually used.
+func extractFields(h *dto.Histogram) histogramFields {
+	f := histogramFields{
+		schema:         h.GetSchema(),
+		zeroThreshold:  h.GetZeroThreshold(),
+		sum:            h.GetSampleSum(),
+		positiveSpans:  convertSpans(h.PositiveSpan),
+		positiveDeltas: h.PositiveDelta,
+		positiveCounts: h.PositiveCount,
+		negativeSpans:  convertSpans(h.NegativeSpan),
+		negativeDeltas: h.NegativeDelta,
+		negativeCounts: h.NegativeCount,
+		isDelta:        h.PositiveDelta != nil || h.NegativeDelta != nil,
+		resetHint:      resetHintGauge,
+	}
+	if h.SampleCountFloat != nil {
+		f.countIsFloat = true
+		f.countFloat = h.GetSampleCountFloat()
+	} else {
+		f.count = h.GetSampleCount()
+	}
+	if h.ZeroCountFloat != nil {
+		f.zeroCountIsFloat = true
+		f.zeroCountFloat = h.GetZeroCountFloat()
+	} else {
+		f.zeroCount = h.GetZeroCount()
+	}
+	return f
+}
+
+func convertSpans(spans []*dto.BucketSpan) []span {
+	out := make([]span, len(spans))
+	for i, s := range spans {
+		out[i] = span{Offset: s.GetOffset(), Length: s.GetLength()}
+	}
+	return out
+}
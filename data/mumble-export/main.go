@@ -0,0 +1,132 @@
+// Tool for exporting histograms stored by promhist to a live Prometheus
+// remote-write receiver.
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var help = flag.Bool("help", false, "Show help")
+var dbfile = flag.String("database", "", "The database to read stored histograms from.")
+var endpoint = flag.String("endpoint", "", "The remote-write endpoint to push to, e.g. http://localhost:9090/api/v1/write.")
+var tenantHeader = flag.String("tenant-header", "", "A \"header-name: value\" pair to attach to every request, e.g. for multi-tenant Mimir/Thanos.")
+var start = flag.Int64("start", 0, "Only export samples at or after this UNIX timestamp.")
+var end = flag.Int64("end", 0, "Only export samples at or before this UNIX timestamp. Defaults to now.")
+var batchSize = flag.Int("batch-size", 500, "Maximum number of samples (stored histograms) to include in one remote-write request.")
+
+// row is one sample read out of monitoring_data, joined with its label set.
+type row struct {
+	labels map[string]string
+	ts     time.Time
+	hist   *dto.Histogram
+}
+
+func main() {
+	flag.Parse()
+	if *help || *dbfile == "" || *endpoint == "" {
+		flag.Usage()
+		return
+	}
+	if *end == 0 {
+		*end = time.Now().Unix()
+	}
+
+	db, err := sql.Open("sqlite3", *dbfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := readRows(db, *start, *end)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("exporting %d samples to %s", len(rows), *endpoint)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	for i := 0; i < len(rows); i += *batchSize {
+		batch := rows[i:min(i+*batchSize, len(rows))]
+		if err := exportBatch(client, *endpoint, *tenantHeader, batch); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// readRows loads every sample in [start, end] from monitoring_data, resolving
+// each row's label set.
+func readRows(db *sql.DB, start, end int64) ([]row, error) {
+	rs, err := db.Query(
+		`SELECT monitoring_data.timestamp, label_set.labels, monitoring_data.data
+		 FROM monitoring_data JOIN label_set ON monitoring_data.label_set_id = label_set.id
+		 WHERE monitoring_data.timestamp BETWEEN ? AND ?
+		 ORDER BY label_set.id, monitoring_data.timestamp;`,
+		time.Unix(start, 0), time.Unix(end, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+
+	var out []row
+	for rs.Next() {
+		var ts time.Time
+		var encodedLabels, data []byte
+		if err := rs.Scan(&ts, &encodedLabels, &data); err != nil {
+			return nil, err
+		}
+		var labels map[string]string
+		if err := json.Unmarshal(encodedLabels, &labels); err != nil {
+			return nil, err
+		}
+		h := &dto.Histogram{}
+		if err := proto.Unmarshal(data, h); err != nil {
+			return nil, err
+		}
+		out = append(out, row{labels: labels, ts: ts, hist: h})
+	}
+	return out, rs.Err()
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// labelKey returns a stable key for grouping samples by label set, since
+// Go maps don't allow map[string]string as a key directly.
+func labelKey(labels map[string]string) string {
+	encoded, err := json.Marshal(labels)
+	if err != nil {
+		// labels always came from json.Unmarshal in readRows, so this
+		// can't actually fail.
+		log.Fatal(err)
+	}
+	return string(encoded)
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openTestDB creates an in-memory database with the same monitoring_data/
+// label_set layout promhist writes to (see denormalized.sql).
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(`CREATE TABLE label_set (id INTEGER PRIMARY KEY, labels TEXT NOT NULL);`); err != nil {
+		t.Fatalf("creating label_set: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE monitoring_data (timestamp TIMESTAMP, label_set_id INTEGER, data BLOB);`); err != nil {
+		t.Fatalf("creating monitoring_data: %v", err)
+	}
+	return db
+}
+
+func TestReadRows(t *testing.T) {
+	db := openTestDB(t)
+
+	labels := map[string]string{"__name__": "cpu", "mode": "user"}
+	encodedLabels, err := json.Marshal(labels)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	res, err := db.Exec(`INSERT INTO label_set (labels) VALUES (?);`, encodedLabels)
+	if err != nil {
+		t.Fatalf("inserting label_set: %v", err)
+	}
+	labelSetID, err := res.LastInsertId()
+	if err != nil {
+		t.Fatalf("LastInsertId() error = %v", err)
+	}
+
+	h := &dto.Histogram{SampleCount: proto.Uint64(3), SampleSum: proto.Float64(1.5)}
+	data, err := proto.Marshal(h)
+	if err != nil {
+		t.Fatalf("proto.Marshal() error = %v", err)
+	}
+	ts := time.Unix(1000, 0).UTC()
+	if _, err := db.Exec(`INSERT INTO monitoring_data VALUES (?, ?, ?);`, ts, labelSetID, data); err != nil {
+		t.Fatalf("inserting monitoring_data: %v", err)
+	}
+
+	rows, err := readRows(db, 0, 2000)
+	if err != nil {
+		t.Fatalf("readRows() error = %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("readRows() returned %d rows, want 1", len(rows))
+	}
+	got := rows[0]
+	if !reflect.DeepEqual(got.labels, labels) {
+		t.Errorf("labels = %v, want %v", got.labels, labels)
+	}
+	if got.ts.Unix() != ts.Unix() {
+		t.Errorf("ts = %v, want %v", got.ts, ts)
+	}
+	if got.hist.GetSampleCount() != 3 || got.hist.GetSampleSum() != 1.5 {
+		t.Errorf("hist = %+v, want count=3 sum=1.5", got.hist)
+	}
+}
+
+func TestReadRowsFiltersByTimeRange(t *testing.T) {
+	db := openTestDB(t)
+
+	encodedLabels, err := json.Marshal(map[string]string{"__name__": "cpu"})
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	res, err := db.Exec(`INSERT INTO label_set (labels) VALUES (?);`, encodedLabels)
+	if err != nil {
+		t.Fatalf("inserting label_set: %v", err)
+	}
+	labelSetID, err := res.LastInsertId()
+	if err != nil {
+		t.Fatalf("LastInsertId() error = %v", err)
+	}
+
+	data, err := proto.Marshal(&dto.Histogram{SampleCount: proto.Uint64(1)})
+	if err != nil {
+		t.Fatalf("proto.Marshal() error = %v", err)
+	}
+	for _, sec := range []int64{500, 1500, 2500} {
+		if _, err := db.Exec(`INSERT INTO monitoring_data VALUES (?, ?, ?);`, time.Unix(sec, 0).UTC(), labelSetID, data); err != nil {
+			t.Fatalf("inserting monitoring_data: %v", err)
+		}
+	}
+
+	rows, err := readRows(db, 1000, 2000)
+	if err != nil {
+		t.Fatalf("readRows() error = %v", err)
+	}
+	if len(rows) != 1 || rows[0].ts.Unix() != 1500 {
+		t.Errorf("readRows(1000, 2000) = %v, want exactly the row at 1500", rows)
+	}
+}
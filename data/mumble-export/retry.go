@@ -0,0 +1,72 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const maxRetries = 5
+
+// postWithRetry sends body to endpoint with the given content type, retrying
+// with exponential backoff on 429 and 5xx responses as recommended by the
+// remote-write spec. It returns the final response's status code (even on
+// the last failed attempt) so the caller can decide whether to fall back to
+// another wire format, e.g. on a 406 Not Acceptable.
+func postWithRetry(client *http.Client, endpoint, tenantHeader string, body []byte, contentType string) (int, error) {
+	backoff := 500 * time.Millisecond
+	var lastStatus int
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Content-Encoding", "snappy")
+		if tenantHeader != "" {
+			name, value, ok := strings.Cut(tenantHeader, ":")
+			if ok {
+				req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+			}
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		lastStatus = resp.StatusCode
+
+		if resp.StatusCode < 300 || resp.StatusCode == http.StatusNotAcceptable {
+			return resp.StatusCode, nil
+		}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return resp.StatusCode, fmt.Errorf("remote-write request rejected with status %d", resp.StatusCode)
+		}
+		if attempt == maxRetries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return lastStatus, fmt.Errorf("remote-write request failed after %d attempts, last status %d", maxRetries+1, lastStatus)
+}
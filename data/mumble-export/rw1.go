@@ -0,0 +1,113 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const rw1ContentType = "application/x-protobuf"
+
+// sendRW1 encodes batch as a Remote Write 1.0 WriteRequest and sends it to
+// endpoint. It's the fallback used when a receiver answers a Remote Write
+// 2.0 request with 406 Not Acceptable.
+func sendRW1(client *http.Client, endpoint, tenantHeader string, batch []row) error {
+	req := &prompb.WriteRequest{Timeseries: buildTimeseriesRW1(batch)}
+	body, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+	encoded := snappy.Encode(nil, body)
+	_, err = postWithRetry(client, endpoint, tenantHeader, encoded, rw1ContentType)
+	return err
+}
+
+func buildTimeseriesRW1(batch []row) []prompb.TimeSeries {
+	// Group samples by label set so each series carries every sample for
+	// that label set in one TimeSeries entry.
+	order := make([]string, 0)
+	series := make(map[string]*prompb.TimeSeries)
+	for _, r := range batch {
+		key := labelKey(r.labels)
+		ts, ok := series[key]
+		if !ok {
+			ts = &prompb.TimeSeries{Labels: sortedLabelsRW1(r.labels)}
+			series[key] = ts
+			order = append(order, key)
+		}
+		ts.Histograms = append(ts.Histograms, fieldsToRW1(extractFields(r.hist), r.ts.UnixMilli()))
+	}
+	out := make([]prompb.TimeSeries, 0, len(order))
+	for _, key := range order {
+		out = append(out, *series[key])
+	}
+	return out
+}
+
+func sortedLabelsRW1(labels map[string]string) []prompb.Label {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]prompb.Label, len(names))
+	for i, name := range names {
+		out[i] = prompb.Label{Name: name, Value: labels[name]}
+	}
+	return out
+}
+
+func fieldsToRW1(f histogramFields, timestampMs int64) prompb.Histogram {
+	h := prompb.Histogram{
+		Timestamp:     timestampMs,
+		Schema:        f.schema,
+		ZeroThreshold: f.zeroThreshold,
+		Sum:           f.sum,
+		PositiveSpans: spansToRW1(f.positiveSpans),
+		NegativeSpans: spansToRW1(f.negativeSpans),
+		ResetHint:     prompb.Histogram_ResetHint(f.resetHint),
+	}
+	if f.zeroCountIsFloat {
+		h.ZeroCount = &prompb.Histogram_ZeroCountFloat{ZeroCountFloat: f.zeroCountFloat}
+	} else {
+		h.ZeroCount = &prompb.Histogram_ZeroCountInt{ZeroCountInt: f.zeroCount}
+	}
+	if f.countIsFloat {
+		h.Count = &prompb.Histogram_CountFloat{CountFloat: f.countFloat}
+	} else {
+		h.Count = &prompb.Histogram_CountInt{CountInt: f.count}
+	}
+	if f.isDelta {
+		h.PositiveDeltas = f.positiveDeltas
+		h.NegativeDeltas = f.negativeDeltas
+	} else {
+		h.PositiveCounts = f.positiveCounts
+		h.NegativeCounts = f.negativeCounts
+	}
+	return h
+}
+
+func spansToRW1(spans []span) []prompb.BucketSpan {
+	out := make([]prompb.BucketSpan, len(spans))
+	for i, s := range spans {
+		out[i] = prompb.BucketSpan{Offset: s.Offset, Length: s.Length}
+	}
+	return out
+}
@@ -0,0 +1,164 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/golang/snappy"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+)
+
+// rw2ContentType identifies a Remote Write 2.0 payload, per the spec.
+const rw2ContentType = "application/x-protobuf;proto=io.prometheus.write.v2.Request"
+
+// exportBatch sends one batch of samples using Remote Write 2.0, falling
+// back to 1.0 if the receiver answers with 406 Not Acceptable (the spec's
+// mechanism for receivers that don't yet understand 2.0).
+func exportBatch(client *http.Client, endpoint, tenantHeader string, batch []row) error {
+	body, err := buildRW2Request(batch)
+	if err != nil {
+		return err
+	}
+	status, err := postWithRetry(client, endpoint, tenantHeader, body, rw2ContentType)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotAcceptable {
+		return sendRW1(client, endpoint, tenantHeader, batch)
+	}
+	return nil
+}
+
+// symbolTable interns strings for Remote Write 2.0's label compression
+// scheme: every label name/value in the request is replaced with an index
+// into one shared Symbols table, and symbol 0 is reserved as the empty
+// string per the spec.
+type symbolTable struct {
+	index   map[string]uint32
+	symbols []string
+}
+
+func newSymbolTable() *symbolTable {
+	return &symbolTable{index: map[string]uint32{"": 0}, symbols: []string{""}}
+}
+
+func (t *symbolTable) intern(s string) uint32 {
+	if idx, ok := t.index[s]; ok {
+		return idx
+	}
+	idx := uint32(len(t.symbols))
+	t.symbols = append(t.symbols, s)
+	t.index[s] = idx
+	return idx
+}
+
+func buildRW2Request(batch []row) ([]byte, error) {
+	symbols := newSymbolTable()
+
+	order := make([]string, 0)
+	series := make(map[string]*writev2.TimeSeries)
+	labelsByKey := make(map[string]map[string]string)
+	for _, r := range batch {
+		key := labelKey(r.labels)
+		ts, ok := series[key]
+		if !ok {
+			ts = &writev2.TimeSeries{}
+			series[key] = ts
+			labelsByKey[key] = r.labels
+			order = append(order, key)
+		}
+		ts.Histograms = append(ts.Histograms, fieldsToRW2(extractFields(r.hist), r.ts.UnixMilli()))
+	}
+
+	out := make([]*writev2.TimeSeries, 0, len(order))
+	for _, key := range order {
+		ts := series[key]
+		ts.LabelsRefs = labelRefs(symbols, labelsByKey[key])
+		out = append(out, ts)
+	}
+
+	req := &writev2.Request{Symbols: symbols.symbols, Timeseries: timeseriesValues(out)}
+	marshaled, err := req.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, marshaled), nil
+}
+
+// timeseriesValues converts []*writev2.TimeSeries to the []writev2.TimeSeries
+// shape the generated Request.Timeseries field expects.
+func timeseriesValues(series []*writev2.TimeSeries) []writev2.TimeSeries {
+	out := make([]writev2.TimeSeries, len(series))
+	for i, ts := range series {
+		out[i] = *ts
+	}
+	return out
+}
+
+// labelRefs returns alternating name/value symbol indices, sorted by label
+// name, as required by the Remote Write 2.0 wire format.
+func labelRefs(symbols *symbolTable, labels map[string]string) []uint32 {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	refs := make([]uint32, 0, 2*len(names))
+	for _, name := range names {
+		refs = append(refs, symbols.intern(name), symbols.intern(labels[name]))
+	}
+	return refs
+}
+
+func fieldsToRW2(f histogramFields, timestampMs int64) writev2.Histogram {
+	h := writev2.Histogram{
+		Timestamp:     timestampMs,
+		Schema:        f.schema,
+		ZeroThreshold: f.zeroThreshold,
+		Sum:           f.sum,
+		PositiveSpans: spansToRW2(f.positiveSpans),
+		NegativeSpans: spansToRW2(f.negativeSpans),
+		ResetHint:     writev2.Histogram_ResetHint(f.resetHint),
+	}
+	if f.zeroCountIsFloat {
+		h.ZeroCount = &writev2.Histogram_ZeroCountFloat{ZeroCountFloat: f.zeroCountFloat}
+	} else {
+		h.ZeroCount = &writev2.Histogram_ZeroCountInt{ZeroCountInt: f.zeroCount}
+	}
+	if f.countIsFloat {
+		h.Count = &writev2.Histogram_CountFloat{CountFloat: f.countFloat}
+	} else {
+		h.Count = &writev2.Histogram_CountInt{CountInt: f.count}
+	}
+	if f.isDelta {
+		h.PositiveDeltas = f.positiveDeltas
+		h.NegativeDeltas = f.negativeDeltas
+	} else {
+		h.PositiveCounts = f.positiveCounts
+		h.NegativeCounts = f.negativeCounts
+	}
+	return h
+}
+
+func spansToRW2(spans []span) []writev2.BucketSpan {
+	out := make([]writev2.BucketSpan, len(spans))
+	for i, s := range spans {
+		out[i] = writev2.BucketSpan{Offset: s.Offset, Length: s.Length}
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// An ingestor reads samples from an input source in a particular exposition
+// format and writes the resulting histograms to the monitoring_data table,
+// resolving each sample's label set through the label_set table.
+type ingestor interface {
+	// Ingest reads from r until EOF, writing one monitoring_data row per
+	// (label set, timestamp) pair it observes.
+	Ingest(db *sql.DB, r io.Reader) error
+}
+
+// newIngestor returns the ingestor registered for the given --format value.
+// csvOpts configures the csv ingestor; other formats ignore it, as their
+// samples carry their own labels and timestamps.
+func newIngestor(format string, csvOpts csvIngestor) (ingestor, error) {
+	switch format {
+	case "csv":
+		return &csvOpts, nil
+	case "prom":
+		return &promIngestor{}, nil
+	case "openmetrics":
+		return &openMetricsIngestor{}, nil
+	case "remote-write":
+		return &remoteWriteIngestor{}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected one of csv, prom, openmetrics, remote-write", format)
+	}
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx, so resolveLabelSet and
+// writeHistogram work the same whether an ingestor writes directly to the
+// database or inside a per-file transaction (see ingestFiles).
+type querier interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// labelSetMu serializes resolveLabelSet's get-or-insert across goroutines.
+// label_set.labels has no unique index, so without this, two ingestFiles
+// workers resolving the same new label set at once could both miss the
+// SELECT and both INSERT, splitting one logical series across two ids.
+var labelSetMu sync.Mutex
+
+// resolveLabelSet looks up the row in label_set matching labels, inserting a
+// new row if one does not already exist, and returns its id.
+func resolveLabelSet(db querier, labels map[string]string) (int, error) {
+	encoded, err := json.Marshal(labels)
+	if err != nil {
+		return 0, err
+	}
+	labelSetMu.Lock()
+	defer labelSetMu.Unlock()
+	var id int
+	err = db.QueryRow("SELECT id FROM label_set WHERE labels = ?;", encoded).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+	err = db.QueryRow("INSERT INTO label_set (labels) VALUES (?) RETURNING id;", encoded).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// writeHistogram serializes h and inserts it into monitoring_data under the
+// given label set id and timestamp.
+func writeHistogram(db querier, ts time.Time, labelSetID int, h *dto.Histogram) error {
+	bytes, err := proto.Marshal(h)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO monitoring_data VALUES(?,?,?);", ts, labelSetID, bytes)
+	return err
+}
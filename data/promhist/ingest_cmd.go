@@ -0,0 +1,144 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// floatListFlag parses a comma-separated list of float64s, e.g.
+// "0.005,0.01,0.025", as a single flag.Value so it can back
+// --bucket-boundaries.
+type floatListFlag []float64
+
+func (f *floatListFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	parts := make([]string, len(*f))
+	for i, v := range *f {
+		parts[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *floatListFlag) Set(s string) error {
+	parts := strings.Split(s, ",")
+	values := make([]float64, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return fmt.Errorf("invalid bucket boundary %q: %w", p, err)
+		}
+		values[i] = v
+	}
+	*f = values
+	return nil
+}
+
+// runIngest implements `promhist ingest`: it reads samples from --input (or
+// stdin) in the format named by --format and writes the resulting
+// histograms to --database. --glob switches to multi-file mode: every file
+// it matches is loaded by --workers goroutines instead.
+func runIngest(args []string) {
+	fs := flag.NewFlagSet("ingest", flag.ExitOnError)
+	help := fs.Bool("help", false, "Show help")
+	format := fs.String("format", "csv", "The format of the input data: csv, prom, openmetrics, or remote-write.")
+	timestamp := fs.Int64("timestamp", 0, "The time to stamp every histogram with. Only used by --format=csv, and only when --bucket-interval is unset.")
+	infile := fs.String("input", "", "The input file. Reads from stdin if unset, except with --format=csv and neither --glob nor --bucket-interval, which requires it.")
+	glob := fs.String("glob", "", "A glob matching multiple CSV files to ingest in parallel, e.g. \"/data/*.csv\". Only used by --format=csv; overrides --input.")
+	workers := fs.Int("workers", 1, "Number of files to ingest concurrently. Only used with --glob.")
+	progress := fs.Bool("progress", false, "Log rows/sec and bytes/sec while ingesting. Only used with --glob.")
+	dbfile := fs.String("database", "", "The database to write to.")
+	name := fs.String("var", "", "The name of the metric. Only used by --format=csv.")
+	label := fs.String("label", "", "The name of the metric label. Only used by --format=csv.")
+	factor := fs.Float64("factor", 1.1, "The factor to use for the native histograms. Only used by --format=csv.")
+	bucketInterval := fs.Duration("bucket-interval", 0, "Bucket CSV rows into windows of this width (e.g. 1m, 5m, 1h) using their own timestamp_secs/timestamp_nanos columns, instead of collapsing the whole file into one histogram stamped at --timestamp. Only used by --format=csv.")
+	align := fs.String("align", "epoch", "Where --bucket-interval window boundaries fall: epoch, calendar-day, or calendar-hour.")
+	onOutOfOrder := fs.String("on-out-of-order", "reject", "What to do with a row whose timestamp precedes the previous row's: reject or sort.")
+	bucketScheme := fs.String("bucket-scheme", "native-exp", "How to encode each histogram's buckets: native-exp, native-linear, or classic-le. Only used by --format=csv.")
+	var bucketBoundaries floatListFlag
+	fs.Var(&bucketBoundaries, "bucket-boundaries", "Comma-separated explicit bucket boundaries, e.g. \"0.005,0.01,0.025\". Required by --bucket-scheme=native-linear and classic-le.")
+	fs.Parse(args)
+
+	if *help || *dbfile == "" || (*format == "csv" && *glob == "" && *bucketInterval == 0 && (*timestamp == 0 || *infile == "")) {
+		fs.Usage()
+		os.Exit(0)
+	}
+
+	csvOpts := csvIngestor{
+		Name: *name, Label: *label, Factor: *factor, Timestamp: *timestamp,
+		BucketInterval: *bucketInterval, Align: *align, OnOutOfOrder: *onOutOfOrder,
+		BucketScheme: *bucketScheme, BucketBoundaries: bucketBoundaries,
+	}
+
+	// Open the database where the histograms should be written.
+	// This database should use the "denormalized.sql" schema.
+	db, err := sql.Open("sqlite3", *dbfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if *glob != "" {
+		if *format != "csv" {
+			log.Fatal("--glob is only supported with --format=csv")
+		}
+		paths, err := filepath.Glob(*glob)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(paths) == 0 {
+			log.Fatalf("--glob %q matched no files", *glob)
+		}
+		if err := ingestFiles(context.Background(), db, &csvOpts, paths, *workers, *progress); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	ing, err := newIngestor(*format, csvOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Open the input, falling back to stdin so formats like remote-write
+	// can be piped in directly from a scrape or dump.
+	var r io.Reader = os.Stdin
+	if *infile != "" {
+		f, err := os.Open(*infile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	if err := ing.Ingest(db, r); err != nil {
+		log.Fatal(err)
+	}
+}
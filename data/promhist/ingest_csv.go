@@ -0,0 +1,218 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	client "github.com/prometheus/client_golang/prometheus"
+)
+
+// csvIngestor implements the original mumble input format: a CSV file whose
+// first two columns are a timestamp and whose remaining columns are values
+// to observe into one histogram per column.
+//
+// By default (BucketInterval zero) every row is collapsed into a single
+// histogram per column, stamped with Timestamp, ignoring the CSV's own
+// timestamp columns. When BucketInterval is set, rows are instead bucketed
+// into time windows of that width using their own timestamp_secs/
+// timestamp_nanos columns, emitting one histogram per (column, window); see
+// windowedObserver.
+type csvIngestor struct {
+	Name      string
+	Label     string
+	Factor    float64
+	Timestamp int64
+
+	BucketInterval time.Duration
+	Align          string
+	OnOutOfOrder   string
+
+	// BucketScheme selects how each histogram's buckets are encoded:
+	// "native-exp" (the default) for exponential native histograms sized by
+	// Factor, "classic-le" for classic Prometheus `le` buckets at
+	// BucketBoundaries, or "native-linear" for linearly-spaced boundaries.
+	// client_golang has no wire format for native histograms with
+	// non-exponential boundaries, so native-linear is also stored as
+	// classic `le` buckets; the __histogram_encoding__ label records which
+	// scheme was requested so a future encoder upgrade can find and
+	// re-encode these rows.
+	BucketScheme     string
+	BucketBoundaries []float64
+}
+
+// encoding returns the effective, defaulted BucketScheme.
+func (c *csvIngestor) encoding() string {
+	if c.BucketScheme == "" {
+		return "native-exp"
+	}
+	return c.BucketScheme
+}
+
+// histogramOpts builds the client_golang options matching BucketScheme.
+func (c *csvIngestor) histogramOpts() (client.HistogramOpts, error) {
+	switch c.encoding() {
+	case "native-exp":
+		return client.HistogramOpts{NativeHistogramBucketFactor: c.Factor}, nil
+	case "classic-le", "native-linear":
+		if len(c.BucketBoundaries) == 0 {
+			return client.HistogramOpts{}, fmt.Errorf("--bucket-boundaries is required for --bucket-scheme=%s", c.BucketScheme)
+		}
+		return client.HistogramOpts{Buckets: c.BucketBoundaries}, nil
+	default:
+		return client.HistogramOpts{}, fmt.Errorf("unknown --bucket-scheme %q, expected native-exp, native-linear, or classic-le", c.BucketScheme)
+	}
+}
+
+// labelSet builds the label set for one value column. labelValue is only
+// used when c.Label is set, i.e. when the CSV has more than one value
+// column; see parseHeader's doc comment for the layout this implements.
+func (c *csvIngestor) labelSet(labelValue string) map[string]string {
+	labels := map[string]string{
+		"__name__":               c.Name,
+		"__histogram_encoding__": c.encoding(),
+	}
+	if c.Label != "" {
+		labels[c.Label] = labelValue
+	}
+	return labels
+}
+
+// parseHeader reads the header of the CSV file and returns the label set ids
+// and histograms to observe into for each value column.
+//
+// The first two columns make up the timestamp.
+// Column 1 is a UNIX timestamp, in seconds since the epoch.
+// Column 2 is the fractional part of the timestamp, in nanoseconds.
+//
+// The remaining columns are labels. If there is only one column, then
+// there will be no label added to the label set. If there is more
+// than one column, then the column header will be used as value of the
+// label specified on the command line.
+//
+// For example, if the CSV file contains the following headers:
+//
+//	"timestamp_secs,timestamp_nanos,user,nice,system,..."
+//
+// and assuming this program is invoked with the following flags:
+//
+//	--var cpu --label mode
+//
+// then the following label sets will be used:
+//
+//	{"__name__": "cpu", "mode": "user"}
+//	{"__name__": "cpu", "mode": "nice"}
+//	{"__name__": "cpu", "mode": "system"}
+//	...
+func (c *csvIngestor) parseHeader(db querier, header []string) ([]int, []client.Histogram, error) {
+	opts, err := c.histogramOpts()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	labelSets := make([]map[string]string, 0)
+	samples := make([]client.Histogram, 0)
+	if c.Label == "" {
+		if header[2] != "value" {
+			return nil, nil, fmt.Errorf("expected 3rd column to be 'value', got %q", header[2])
+		}
+		labelSets = append(labelSets, c.labelSet(c.Name))
+		samples = append(samples, client.NewHistogram(opts))
+	} else {
+		for i := 2; i < len(header); i++ {
+			labelSets = append(labelSets, c.labelSet(header[i]))
+			samples = append(samples, client.NewHistogram(opts))
+		}
+	}
+	labelIDs := make([]int, len(labelSets))
+	for i, labels := range labelSets {
+		id, err := resolveLabelSet(db, labels)
+		if err != nil {
+			return nil, nil, err
+		}
+		labelIDs[i] = id
+	}
+	return labelIDs, samples, nil
+}
+
+func (c *csvIngestor) Ingest(db *sql.DB, r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	labelIDs, samples, err := c.parseHeader(db, header)
+	if err != nil {
+		return err
+	}
+	w, err := newWindowedObserver(c, len(samples))
+	if err != nil {
+		return err
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.observeRow(w, record, samples); err != nil {
+			return err
+		}
+	}
+	return w.flush(db, labelIDs)
+}
+
+// observeRow parses one CSV row's timestamp and values and hands them to w.
+// samples is only used to size the value slice; its histograms themselves
+// are unused once windowing was introduced (the histograms actually
+// observed into live inside w, one set per window).
+func (c *csvIngestor) observeRow(w *windowedObserver, record []string, samples []client.Histogram) error {
+	ts, err := parseRowTimestamp(record)
+	if err != nil {
+		return err
+	}
+	values := make([]float64, len(samples))
+	for i := range samples {
+		v, err := strconv.ParseFloat(record[i+2], 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse value %v: %w", record[i+2], err)
+		}
+		values[i] = v
+	}
+	return w.observe(ts, values)
+}
+
+// parseRowTimestamp reads the timestamp_secs/timestamp_nanos columns every
+// row carries, per parseHeader's documented layout.
+func parseRowTimestamp(record []string) (time.Time, error) {
+	secs, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse timestamp_secs %q: %w", record[0], err)
+	}
+	nanos, err := strconv.ParseInt(record[1], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse timestamp_nanos %q: %w", record[1], err)
+	}
+	return time.Unix(secs, nanos).UTC(), nil
+}
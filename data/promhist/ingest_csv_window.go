@@ -0,0 +1,139 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	client "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// windowedObserver buckets CSV rows into time windows and keeps one
+// histogram per (column, window) pair. With BucketInterval zero every row
+// is folded into a single window stamped at Timestamp, reproducing mumble's
+// original one-histogram-per-file behavior.
+type windowedObserver struct {
+	c       *csvIngestor
+	opts    client.HistogramOpts
+	numCols int
+	windows map[time.Time][]client.Histogram
+
+	lastTs   time.Time
+	haveLast bool
+}
+
+func newWindowedObserver(c *csvIngestor, numCols int) (*windowedObserver, error) {
+	opts, err := c.histogramOpts()
+	if err != nil {
+		return nil, err
+	}
+	return &windowedObserver{c: c, opts: opts, numCols: numCols, windows: make(map[time.Time][]client.Histogram)}, nil
+}
+
+// observe routes one row's values into the window its timestamp falls in,
+// creating the window's histograms on first use.
+//
+// Rows are expected to arrive in non-decreasing timestamp order. When they
+// don't, OnOutOfOrder controls what happens: "reject" (the default) returns
+// an error describing the offending row, while "sort" lets the row through
+// regardless, since bucketing by window key already routes it correctly no
+// matter what order it arrives in.
+func (w *windowedObserver) observe(ts time.Time, values []float64) error {
+	if w.c.BucketInterval > 0 {
+		if w.haveLast && ts.Before(w.lastTs) && w.c.OnOutOfOrder != "sort" {
+			return fmt.Errorf("non-monotonic timestamp: %v came after %v; pass --on-out-of-order=sort to bucket out-of-order rows anyway", ts, w.lastTs)
+		}
+		w.lastTs = ts
+		w.haveLast = true
+	}
+
+	start, err := w.windowStart(ts)
+	if err != nil {
+		return err
+	}
+	hists, ok := w.windows[start]
+	if !ok {
+		hists = make([]client.Histogram, w.numCols)
+		for i := range hists {
+			hists[i] = client.NewHistogram(w.opts)
+		}
+		w.windows[start] = hists
+	}
+	for i, v := range values {
+		hists[i].Observe(v)
+	}
+	return nil
+}
+
+// windowStart returns the start of the window ts belongs to.
+func (w *windowedObserver) windowStart(ts time.Time) (time.Time, error) {
+	if w.c.BucketInterval == 0 {
+		return time.Unix(w.c.Timestamp, 0), nil
+	}
+	return alignedWindowStart(ts, w.c.BucketInterval, w.c.Align)
+}
+
+// alignedWindowStart buckets ts into a window of the given width, with
+// boundaries placed according to align: "epoch" (the default) places
+// boundaries at multiples of interval since the UNIX epoch; "calendar-day"
+// and "calendar-hour" instead reset to a window boundary at each UTC day or
+// hour, so e.g. a 15-minute interval with calendar-hour always starts a new
+// window at the top of the hour.
+func alignedWindowStart(ts time.Time, interval time.Duration, align string) (time.Time, error) {
+	if interval <= 0 {
+		return time.Time{}, fmt.Errorf("--bucket-interval must be positive")
+	}
+	ts = ts.UTC()
+	var epoch time.Time
+	switch align {
+	case "", "epoch":
+		epoch = time.Unix(0, 0).UTC()
+	case "calendar-day":
+		epoch = time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, time.UTC)
+	case "calendar-hour":
+		epoch = time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), 0, 0, 0, time.UTC)
+	default:
+		return time.Time{}, fmt.Errorf("unknown --align value %q, expected epoch, calendar-day, or calendar-hour", align)
+	}
+	elapsed := ts.Sub(epoch)
+	return epoch.Add(elapsed - elapsed%interval), nil
+}
+
+// flush writes one monitoring_data row per (window, column), in ascending
+// window order, so the rows a run produces don't depend on the order its
+// input rows arrived in.
+func (w *windowedObserver) flush(db querier, labelIDs []int) error {
+	starts := make([]time.Time, 0, len(w.windows))
+	for t := range w.windows {
+		starts = append(starts, t)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	for _, t := range starts {
+		hists := w.windows[t]
+		for i, h := range hists {
+			metric := &dto.Metric{}
+			h.Write(metric)
+			if err := writeHistogram(db, t, labelIDs[i], metric.Histogram); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ingestFiles ingests every path in paths using workers goroutines, each
+// owning an independent *sql.Conn so files can be read and written in
+// parallel without serializing on a single connection. Each file is
+// ingested inside its own transaction, so a crash partway through a
+// directory leaves already-finished files committed and the rest untouched.
+//
+// ingestFiles puts db in WAL mode itself so readers
+// don't block behind writers the way SQLite's default rollback-journal mode
+// would. WAL still only allows one writer to commit at a time, though, so
+// each worker's connection also gets a busy_timeout: without it, two workers
+// committing close together get "database is locked" instead of one of them
+// just waiting its turn.
+func ingestFiles(ctx context.Context, db *sql.DB, c *csvIngestor, paths []string, workers int, progress bool) error {
+	if workers < 1 {
+		return fmt.Errorf("ingestFiles: workers must be >= 1, got %d", workers)
+	}
+	if _, err := db.ExecContext(ctx, "PRAGMA journal_mode=WAL;"); err != nil {
+		return fmt.Errorf("enabling WAL mode: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, "PRAGMA synchronous=NORMAL;"); err != nil {
+		return fmt.Errorf("setting synchronous=NORMAL: %w", err)
+	}
+
+	// Workers stop pulling from jobs as soon as one of them hits an error, so
+	// the path-feeding loop below can't be allowed to block on a send that
+	// nothing will ever receive; cancel lets it bail out instead of
+	// deadlocking the moment a worker exits early.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var stats progressStats
+	stop := make(chan struct{})
+	if progress {
+		go reportProgress(&stats, stop)
+	}
+
+	jobs := make(chan string)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := db.Conn(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			if _, err := conn.ExecContext(ctx, "PRAGMA busy_timeout=5000;"); err != nil {
+				errs <- fmt.Errorf("setting busy_timeout: %w", err)
+				return
+			}
+			for path := range jobs {
+				if err := ingestFile(ctx, conn, c, path, &stats); err != nil {
+					errs <- fmt.Errorf("%s: %w", path, err)
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, path := range paths {
+			select {
+			case jobs <- path:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	if progress {
+		close(stop)
+	}
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ingestFile loads one CSV file inside a single transaction on conn. A
+// reader goroutine parses records off the file and hands them to this
+// goroutine (the observer) over a bounded channel, so a multi-gigabyte file
+// is processed a row at a time rather than held in memory.
+func ingestFile(ctx context.Context, conn *sql.Conn, c *csvIngestor, path string, stats *progressStats) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	reader := csv.NewReader(f)
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("reading header: %w", err)
+	}
+	labelIDs, samples, err := c.parseHeader(tx, header)
+	if err != nil {
+		return err
+	}
+
+	const channelSize = 256
+	records := make(chan []string, channelSize)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(records)
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				readErr <- nil
+				return
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+			var size int64
+			for _, field := range record {
+				size += int64(len(field))
+			}
+			atomic.AddInt64(&stats.bytes, size)
+			records <- record
+		}
+	}()
+
+	w, err := newWindowedObserver(c, len(samples))
+	if err != nil {
+		return err
+	}
+	for record := range records {
+		if err := c.observeRow(w, record, samples); err != nil {
+			return err
+		}
+		atomic.AddInt64(&stats.rows, 1)
+	}
+	if err := <-readErr; err != nil {
+		return err
+	}
+
+	if err := w.flush(tx, labelIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// progressStats holds the running totals reportProgress logs periodically.
+// Fields are updated with sync/atomic from worker goroutines.
+type progressStats struct {
+	rows  int64
+	bytes int64
+}
+
+// reportProgress logs rows/sec and bytes/sec once a second until stop is
+// closed.
+func reportProgress(stats *progressStats, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var lastRows, lastBytes int64
+	for {
+		select {
+		case <-ticker.C:
+			rows := atomic.LoadInt64(&stats.rows)
+			bytes := atomic.LoadInt64(&stats.bytes)
+			log.Printf("%d rows/sec, %d bytes/sec (%d rows total)", rows-lastRows, bytes-lastBytes, rows)
+			lastRows, lastBytes = rows, bytes
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"io"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// openMetricsIngestor reads an OpenMetrics text payload. It is a thin
+// wrapper around promIngestor's per-family handling: the OpenMetrics decoder
+// already folds classic `_bucket`/`_sum`/`_count` series and native
+// (exponential) histogram fields into the same dto.Histogram shape used by
+// the Prometheus text format, so both formats share writeFamily.
+type openMetricsIngestor struct{}
+
+func (o *openMetricsIngestor) Ingest(db *sql.DB, r io.Reader) error {
+	decoder := expfmt.NewDecoder(r, expfmt.NewFormat(expfmt.TypeOpenMetrics))
+	now := time.Now()
+	for {
+		var family dto.MetricFamily
+		if err := decoder.Decode(&family); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if family.GetType() != dto.MetricType_HISTOGRAM {
+			continue
+		}
+		if err := writeFamily(db, &family, now); err != nil {
+			return err
+		}
+	}
+}
+
+// writeFamily resolves and writes one monitoring_data row per metric in a
+// histogram-typed family, falling back to "now" when a sample has no
+// timestamp of its own.
+func writeFamily(db *sql.DB, family *dto.MetricFamily, now time.Time) error {
+	for _, metric := range family.Metric {
+		labels := map[string]string{"__name__": family.GetName()}
+		for _, pair := range metric.Label {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		id, err := resolveLabelSet(db, labels)
+		if err != nil {
+			return err
+		}
+		ts := now
+		if ms := metric.GetTimestampMs(); ms != 0 {
+			ts = time.UnixMilli(ms)
+		}
+		if err := writeHistogram(db, ts, id, metric.Histogram); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"io"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// promIngestor reads a Prometheus text exposition payload (the default
+// content type scraped from a /metrics endpoint) and writes one row to
+// monitoring_data per (label set, histogram) it finds. Unlike csvIngestor,
+// samples already carry their own timestamp; when a metric has none, the
+// time the payload was ingested is used instead.
+type promIngestor struct{}
+
+func (p *promIngestor) Ingest(db *sql.DB, r io.Reader) error {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for name, family := range families {
+		if family.GetType() != dto.MetricType_HISTOGRAM {
+			continue
+		}
+		family.Name = &name
+		if err := writeFamily(db, family, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
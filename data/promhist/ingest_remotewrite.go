@@ -0,0 +1,120 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// remoteWriteIngestor reads a snappy-framed prompb.WriteRequest, the payload
+// shape a Prometheus server sends to a remote_write receiver, and writes one
+// monitoring_data row per histogram sample it contains. Float and exemplar
+// samples carry no histogram and are skipped; mumble only archives
+// histograms.
+type remoteWriteIngestor struct{}
+
+func (rw *remoteWriteIngestor) Ingest(db *sql.DB, r io.Reader) error {
+	framed, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	encoded, err := snappy.Decode(nil, framed)
+	if err != nil {
+		return fmt.Errorf("failed to un-frame remote-write payload: %w", err)
+	}
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(encoded); err != nil {
+		return fmt.Errorf("failed to unmarshal WriteRequest: %w", err)
+	}
+	for _, series := range req.Timeseries {
+		labels := make(map[string]string, len(series.Labels))
+		for _, l := range series.Labels {
+			labels[l.Name] = l.Value
+		}
+		if len(series.Histograms) == 0 {
+			continue
+		}
+		id, err := resolveLabelSet(db, labels)
+		if err != nil {
+			return err
+		}
+		for _, h := range series.Histograms {
+			hist, err := histogramFromPrompb(h)
+			if err != nil {
+				return err
+			}
+			if err := writeHistogram(db, time.UnixMilli(h.Timestamp), id, hist); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// histogramFromPrompb converts a prompb.Histogram, as found in a remote_write
+// request, into the dto.Histogram shape mumble stores in monitoring_data.
+// It handles both the int-count and float-count variants prompb uses for
+// cumulative and gauge-style (delta) histograms.
+func histogramFromPrompb(h prompb.Histogram) (*dto.Histogram, error) {
+	out := &dto.Histogram{
+		Schema:        proto.Int32(h.Schema),
+		ZeroThreshold: proto.Float64(h.ZeroThreshold),
+	}
+	switch h.ZeroCount.(type) {
+	case *prompb.Histogram_ZeroCountInt:
+		out.ZeroCount = proto.Uint64(h.GetZeroCountInt())
+	case *prompb.Histogram_ZeroCountFloat:
+		out.ZeroCountFloat = proto.Float64(h.GetZeroCountFloat())
+	}
+	switch h.Count.(type) {
+	case *prompb.Histogram_CountInt:
+		out.SampleCount = proto.Uint64(h.GetCountInt())
+	case *prompb.Histogram_CountFloat:
+		out.SampleCountFloat = proto.Float64(h.GetCountFloat())
+	}
+	out.SampleSum = proto.Float64(h.Sum)
+	out.PositiveSpan = convertSpans(h.PositiveSpans)
+	out.NegativeSpan = convertSpans(h.NegativeSpans)
+	switch h.Count.(type) {
+	case *prompb.Histogram_CountInt:
+		out.PositiveDelta = h.PositiveDeltas
+		out.NegativeDelta = h.NegativeDeltas
+	default:
+		out.PositiveCount = h.PositiveCounts
+		out.NegativeCount = h.NegativeCounts
+	}
+	return out, nil
+}
+
+func convertSpans(spans []prompb.BucketSpan) []*dto.BucketSpan {
+	out := make([]*dto.BucketSpan, len(spans))
+	for i, s := range spans {
+		out[i] = &dto.BucketSpan{
+			Offset: proto.Int32(s.Offset),
+			Length: proto.Uint32(s.Length),
+		}
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/TonyRippy/mumble/pkg/nhmerge"
+)
+
+// runMerge implements `promhist merge`: it reads every stored histogram for
+// a label set over a time range, merges them into one at --schema, and
+// writes the result back to monitoring_data.
+func runMerge(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	dbfile := fs.String("database", "", "The database to read from and write to.")
+	labelSetID := fs.Int("label-set", 0, "The id of the label_set row to merge histograms for.")
+	start := fs.Int64("start", 0, "Start of the time range to merge, as a UNIX timestamp (inclusive).")
+	end := fs.Int64("end", 0, "End of the time range to merge, as a UNIX timestamp (inclusive).")
+	schema := fs.Int("schema", math.MinInt32, "The target schema (bucket factor) to merge to. Defaults to the coarsest schema among the inputs.")
+	forceSchema := fs.Bool("force-schema", false, "Allow merging histograms recorded at incompatible schemas or zero thresholds by downscaling them all to --schema.")
+	timestamp := fs.Int64("timestamp", 0, "The time to stamp the merged histogram with. Defaults to --end.")
+	fs.Parse(args)
+
+	if *dbfile == "" || *labelSetID == 0 || *end == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	db, err := sql.Open("sqlite3", *dbfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(
+		"SELECT data FROM monitoring_data WHERE label_set_id = ? AND timestamp BETWEEN ? AND ? ORDER BY timestamp;",
+		*labelSetID, time.Unix(*start, 0), time.Unix(*end, 0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	var hists []*dto.Histogram
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			log.Fatal(err)
+		}
+		h := &dto.Histogram{}
+		if err := proto.Unmarshal(data, h); err != nil {
+			log.Fatal(err)
+		}
+		hists = append(hists, h)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	if len(hists) == 0 {
+		log.Fatalf("no histograms found for label_set %d in the given range", *labelSetID)
+	}
+
+	coarsest := hists[0].GetSchema()
+	threshold := hists[0].GetZeroThreshold()
+	for _, h := range hists[1:] {
+		if h.GetSchema() != coarsest || h.GetZeroThreshold() != threshold {
+			if !*forceSchema {
+				log.Fatalf("histograms have incompatible schemas/zero-thresholds (schema %d threshold %v vs schema %d threshold %v); pass --force-schema to downscale",
+					coarsest, threshold, h.GetSchema(), h.GetZeroThreshold())
+			}
+			if h.GetSchema() < coarsest {
+				coarsest = h.GetSchema()
+			}
+		}
+	}
+	targetSchema := int32(*schema)
+	if *schema == math.MinInt32 {
+		targetSchema = coarsest
+	}
+
+	merged, err := nhmerge.Merge(hists, targetSchema)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ts := time.Unix(*end, 0)
+	if *timestamp != 0 {
+		ts = time.Unix(*timestamp, 0)
+	}
+	if err := writeHistogram(db, ts, *labelSetID, merged); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,269 @@
+// Package nhmerge merges multiple Prometheus native histograms into one,
+// re-bucketing them to a common schema (bucket factor) along the way.
+//
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nhmerge
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Merge combines hists into a single native histogram at targetSchema.
+//
+// All inputs must be native histograms using the same bucketing scheme
+// (either all exponential-schema histograms or all fixed-schema), and
+// targetSchema must not be finer than the coarsest input schema: Merge only
+// reduces resolution, by repeatedly pairwise-summing adjacent buckets, it
+// never invents resolution that wasn't already there. Merge reduces each
+// input to targetSchema itself (see ReduceResolution), so inputs recorded at
+// different schemas are accepted as long as none is coarser than
+// targetSchema, and differing zero thresholds are widened to the largest.
+// Merge does not reject such mixes; any --force-schema style check belongs
+// at the call site.
+//
+// Cumulative histograms (the default client_golang encoding, using
+// PositiveDelta/NegativeDelta) and gauge histograms (PositiveCount/
+// NegativeCount) may be mixed; the result is encoded as a gauge histogram,
+// since a sum of cumulative histograms over a time range is itself a
+// point-in-time snapshot rather than a running counter.
+func Merge(hists []*dto.Histogram, targetSchema int32) (*dto.Histogram, error) {
+	if len(hists) == 0 {
+		return nil, fmt.Errorf("nhmerge: no histograms to merge")
+	}
+	zeroThreshold := float64(0)
+	var sampleCount, sampleSum float64
+	var createdTimestamp *dto.Histogram
+	positive := make(map[int32]float64)
+	negative := make(map[int32]float64)
+
+	for _, h := range hists {
+		if h.GetSchema() < targetSchema {
+			return nil, fmt.Errorf("nhmerge: histogram schema %d is coarser than target schema %d", h.GetSchema(), targetSchema)
+		}
+		if h.GetZeroThreshold() > zeroThreshold {
+			zeroThreshold = h.GetZeroThreshold()
+		}
+		sampleCount += count(h)
+		sampleSum += h.GetSampleSum()
+		if h.CreatedTimestamp != nil && (createdTimestamp == nil || h.CreatedTimestamp.AsTime().Before(createdTimestamp.CreatedTimestamp.AsTime())) {
+			createdTimestamp = h
+		}
+
+		reduced, err := ReduceResolution(h, targetSchema)
+		if err != nil {
+			return nil, err
+		}
+		addBuckets(positive, expand(reduced.PositiveSpan, reduced.PositiveDelta, reduced.PositiveCount))
+		addBuckets(negative, expand(reduced.NegativeSpan, reduced.NegativeDelta, reduced.NegativeCount))
+	}
+
+	// Fold any bucket that now falls within the widened zero threshold into
+	// the zero count, so merging histograms with different thresholds
+	// doesn't double-count observations near zero.
+	zeroCount := zeroFold(positive, targetSchema, zeroThreshold) + zeroFold(negative, targetSchema, zeroThreshold)
+	for _, h := range hists {
+		zeroCount += zeroCountOf(h)
+	}
+
+	posSpans, posCounts := compress(positive)
+	negSpans, negCounts := compress(negative)
+
+	out := &dto.Histogram{
+		SampleCountFloat: &sampleCount,
+		SampleSum:        &sampleSum,
+		Schema:           &targetSchema,
+		ZeroThreshold:    &zeroThreshold,
+		ZeroCountFloat:   &zeroCount,
+		PositiveSpan:     posSpans,
+		PositiveCount:    posCounts,
+		NegativeSpan:     negSpans,
+		NegativeCount:    negCounts,
+	}
+	if createdTimestamp != nil {
+		out.CreatedTimestamp = createdTimestamp.CreatedTimestamp
+	}
+	return out, nil
+}
+
+// ReduceResolution returns a copy of h re-bucketed to targetSchema by
+// repeatedly halving resolution: each step pairwise-sums the two buckets
+// that make up one bucket at the coarser schema. It leaves the zero bucket,
+// sum, and count untouched.
+func ReduceResolution(h *dto.Histogram, targetSchema int32) (*dto.Histogram, error) {
+	if targetSchema > h.GetSchema() {
+		return nil, fmt.Errorf("nhmerge: cannot increase resolution from schema %d to %d", h.GetSchema(), targetSchema)
+	}
+	positive := expand(h.PositiveSpan, h.PositiveDelta, h.PositiveCount)
+	negative := expand(h.NegativeSpan, h.NegativeDelta, h.NegativeCount)
+	for schema := h.GetSchema(); schema > targetSchema; schema-- {
+		positive = halve(positive)
+		negative = halve(negative)
+	}
+	posSpans, posCounts := compress(positive)
+	negSpans, negCounts := compress(negative)
+	return &dto.Histogram{
+		SampleCount:      h.SampleCount,
+		SampleCountFloat: h.SampleCountFloat,
+		SampleSum:        h.SampleSum,
+		Schema:           &targetSchema,
+		ZeroThreshold:    h.ZeroThreshold,
+		ZeroCount:        h.ZeroCount,
+		ZeroCountFloat:   h.ZeroCountFloat,
+		PositiveSpan:     posSpans,
+		PositiveCount:    posCounts,
+		NegativeSpan:     negSpans,
+		NegativeCount:    negCounts,
+		CreatedTimestamp: h.CreatedTimestamp,
+	}, nil
+}
+
+func count(h *dto.Histogram) float64 {
+	if h.SampleCountFloat != nil {
+		return h.GetSampleCountFloat()
+	}
+	return float64(h.GetSampleCount())
+}
+
+// zeroCountOf returns a histogram's zero-bucket count as a float64,
+// regardless of whether it was encoded as the int ZeroCount (cumulative,
+// counter-style histograms) or the float ZeroCountFloat (gauge-style); only
+// one of the two is ever populated on a given histogram.
+func zeroCountOf(h *dto.Histogram) float64 {
+	if h.ZeroCountFloat != nil {
+		return h.GetZeroCountFloat()
+	}
+	return float64(h.GetZeroCount())
+}
+
+// expand decodes a span/delta or span/count encoded bucket run into a map
+// from absolute bucket index to absolute observation count. Exactly one of
+// deltas or counts should be non-nil; deltas are cumulative (client_golang's
+// counter-style encoding), counts are already absolute (gauge-style).
+func expand(spans []*dto.BucketSpan, deltas []int64, counts []float64) map[int32]float64 {
+	out := make(map[int32]float64)
+	idx := int32(0)
+	bucket := 0
+	running := float64(0)
+	if deltas != nil {
+		for _, span := range spans {
+			idx += span.GetOffset()
+			for i := uint32(0); i < span.GetLength(); i++ {
+				running += float64(deltas[bucket])
+				out[idx] += running
+				idx++
+				bucket++
+			}
+		}
+		return out
+	}
+	for _, span := range spans {
+		idx += span.GetOffset()
+		for i := uint32(0); i < span.GetLength(); i++ {
+			out[idx] += counts[bucket]
+			idx++
+			bucket++
+		}
+	}
+	return out
+}
+
+// compress re-encodes a bucket-index->count map as ascending spans with
+// gauge-style (absolute) counts, skipping runs of empty buckets.
+func compress(buckets map[int32]float64) ([]*dto.BucketSpan, []float64) {
+	if len(buckets) == 0 {
+		return nil, nil
+	}
+	indices := make([]int32, 0, len(buckets))
+	for idx := range buckets {
+		indices = append(indices, idx)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+
+	var spans []*dto.BucketSpan
+	var counts []float64
+	var cur *dto.BucketSpan
+	prev := int32(0)
+	for _, idx := range indices {
+		// offset is the number of empty buckets between the previous bucket
+		// emitted (exclusive) and this one, matching what expand expects: its
+		// running idx pointer sits just past the last bucket written, so the
+		// first span's offset is still relative to bucket 0.
+		offset := idx - prev - 1
+		if cur == nil {
+			offset = idx
+		}
+		if cur != nil && offset == 0 {
+			cur.Length = proto32(cur.GetLength() + 1)
+		} else {
+			cur = &dto.BucketSpan{Offset: proto32i(offset), Length: proto32(1)}
+			spans = append(spans, cur)
+		}
+		counts = append(counts, buckets[idx])
+		prev = idx
+	}
+	return spans, counts
+}
+
+// halve merges the two adjacent buckets that make up one bucket at the next
+// coarser schema, assuming base-2 exponential bucketing. Bucket index i maps
+// to floor(i/2) at the coarser schema, which keeps pairs (..,-1,0),(1,2),...
+// consistently grouped regardless of sign.
+func halve(buckets map[int32]float64) map[int32]float64 {
+	out := make(map[int32]float64, len(buckets)/2+1)
+	for idx, v := range buckets {
+		out[floorDiv2(idx)] += v
+	}
+	return out
+}
+
+func floorDiv2(i int32) int32 {
+	if i >= 0 {
+		return i / 2
+	}
+	return -((-i + 1) / 2)
+}
+
+func addBuckets(dst, src map[int32]float64) {
+	for idx, v := range src {
+		dst[idx] += v
+	}
+}
+
+// zeroFold removes every bucket whose boundary now falls entirely inside
+// zeroThreshold from buckets and returns the observation count it held.
+func zeroFold(buckets map[int32]float64, schema int32, zeroThreshold float64) float64 {
+	var folded float64
+	for idx, v := range buckets {
+		if bucketUpperBound(schema, idx) <= zeroThreshold {
+			folded += v
+			delete(buckets, idx)
+		}
+	}
+	return folded
+}
+
+// bucketUpperBound returns the upper inclusive boundary of the bucket at idx
+// under an exponential schema, i.e. base^idx where base = 2^(2^-schema).
+func bucketUpperBound(schema, idx int32) float64 {
+	return math.Exp2(float64(idx) * math.Exp2(-float64(schema)))
+}
+
+func proto32(v uint32) *uint32 { return &v }
+func proto32i(v int32) *int32  { return &v }
@@ -0,0 +1,213 @@
+// Copyright (C) 2023, Tony Rippy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file at the
+// root of this repository, or online at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nhmerge
+
+import (
+	"reflect"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func f64p(v float64) *float64 { return &v }
+func u64p(v uint64) *uint64   { return &v }
+func i32p(v int32) *int32     { return &v }
+func u32p(v uint32) *uint32   { return &v }
+
+func TestFloorDiv2(t *testing.T) {
+	cases := []struct {
+		in, want int32
+	}{
+		{0, 0}, {1, 0}, {2, 1}, {3, 1},
+		{-1, -1}, {-2, -1}, {-3, -2}, {-4, -2},
+	}
+	for _, c := range cases {
+		if got := floorDiv2(c.in); got != c.want {
+			t.Errorf("floorDiv2(%d) = %d, want %d", c.in, got, c.want)
+		}
+	}
+}
+
+func TestHalve(t *testing.T) {
+	in := map[int32]float64{0: 1, 1: 2, 2: 4, -1: 8, -2: 16}
+	got := halve(in)
+	want := map[int32]float64{0: 3, 1: 4, -1: 24}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("halve(%v) = %v, want %v", in, got, want)
+	}
+}
+
+func TestExpandDeltas(t *testing.T) {
+	// Two spans: bucket 0, then buckets 3-4 (offset 2 after bucket 0).
+	spans := []*dto.BucketSpan{
+		{Offset: i32p(0), Length: u32p(1)},
+		{Offset: i32p(2), Length: u32p(2)},
+	}
+	deltas := []int64{3, -1, 2}
+	got := expand(spans, deltas, nil)
+	// Deltas are cumulative: bucket 0 = 3, bucket 3 = 3-1 = 2, bucket 4 = 2+2 = 4.
+	want := map[int32]float64{0: 3, 3: 2, 4: 4}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expand(deltas) = %v, want %v", got, want)
+	}
+}
+
+func TestExpandCounts(t *testing.T) {
+	spans := []*dto.BucketSpan{{Offset: i32p(0), Length: u32p(2)}}
+	counts := []float64{5, 7}
+	got := expand(spans, nil, counts)
+	want := map[int32]float64{0: 5, 1: 7}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expand(counts) = %v, want %v", got, want)
+	}
+}
+
+func TestCompressExpandRoundTrip(t *testing.T) {
+	in := map[int32]float64{-3: 1, 0: 2, 1: 3, 5: 4}
+	spans, counts := compress(in)
+	got := expand(spans, nil, counts)
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("expand(compress(%v)) = %v, want %v", in, got, in)
+	}
+}
+
+func TestZeroFold(t *testing.T) {
+	// At schema 0, bucket 0's upper bound is 2^0 = 1.
+	buckets := map[int32]float64{-1: 1, 0: 2, 1: 4}
+	folded := zeroFold(buckets, 0, 1.5)
+	if folded != 3 {
+		t.Errorf("zeroFold folded = %v, want 3", folded)
+	}
+	want := map[int32]float64{1: 4}
+	if !reflect.DeepEqual(buckets, want) {
+		t.Errorf("zeroFold left %v, want %v", buckets, want)
+	}
+}
+
+func TestReduceResolution(t *testing.T) {
+	h := &dto.Histogram{
+		Schema:        i32p(0),
+		SampleCount:   u64p(10),
+		SampleSum:     f64p(42),
+		ZeroThreshold: f64p(0),
+		ZeroCount:     u64p(0),
+		PositiveSpan:  []*dto.BucketSpan{{Offset: i32p(0), Length: u32p(4)}},
+		PositiveDelta: []int64{1, 0, 0, 0},
+	}
+	reduced, err := ReduceResolution(h, -1)
+	if err != nil {
+		t.Fatalf("ReduceResolution() error = %v", err)
+	}
+	if reduced.GetSchema() != -1 {
+		t.Errorf("reduced schema = %d, want -1", reduced.GetSchema())
+	}
+	got := expand(reduced.PositiveSpan, nil, reduced.PositiveCount)
+	// Buckets 0-3 each hold count 1; halving pairs (0,1) and (2,3).
+	want := map[int32]float64{0: 2, 1: 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("reduced positive buckets = %v, want %v", got, want)
+	}
+
+	if _, err := ReduceResolution(h, 1); err == nil {
+		t.Error("ReduceResolution() with a finer target schema should have failed")
+	}
+}
+
+func TestMergeRejectsEmpty(t *testing.T) {
+	if _, err := Merge(nil, 0); err == nil {
+		t.Error("Merge() with no histograms should have failed")
+	}
+}
+
+func TestMergeRejectsFinerSchema(t *testing.T) {
+	h := &dto.Histogram{Schema: i32p(0), SampleCount: u64p(1), SampleSum: f64p(1)}
+	if _, err := Merge([]*dto.Histogram{h}, 1); err == nil {
+		t.Error("Merge() targeting a finer schema than an input should have failed")
+	}
+}
+
+func TestMergeMixedDeltaAndCount(t *testing.T) {
+	// A cumulative (delta-encoded) histogram and a gauge (count-encoded)
+	// snapshot, both at schema 0, both observing bucket 0 only.
+	cumulative := &dto.Histogram{
+		Schema:        i32p(0),
+		SampleCount:   u64p(3),
+		SampleSum:     f64p(6),
+		ZeroThreshold: f64p(0),
+		ZeroCount:     u64p(0),
+		PositiveSpan:  []*dto.BucketSpan{{Offset: i32p(0), Length: u32p(1)}},
+		PositiveDelta: []int64{3},
+	}
+	gauge := &dto.Histogram{
+		Schema:           i32p(0),
+		SampleCountFloat: f64p(2),
+		SampleSum:        f64p(4),
+		ZeroThreshold:    f64p(0),
+		ZeroCountFloat:   f64p(0),
+		PositiveSpan:     []*dto.BucketSpan{{Offset: i32p(0), Length: u32p(1)}},
+		PositiveCount:    []float64{2},
+	}
+
+	out, err := Merge([]*dto.Histogram{cumulative, gauge}, 0)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if out.GetSampleCountFloat() != 5 {
+		t.Errorf("merged count = %v, want 5", out.GetSampleCountFloat())
+	}
+	if out.GetSampleSum() != 10 {
+		t.Errorf("merged sum = %v, want 10", out.GetSampleSum())
+	}
+	got := expand(out.PositiveSpan, nil, out.PositiveCount)
+	want := map[int32]float64{0: 5}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged positive buckets = %v, want %v", got, want)
+	}
+}
+
+func TestMergeFoldsZeroThreshold(t *testing.T) {
+	// Bucket 0's upper bound at schema 0 is 2^0 = 1, so widening the zero
+	// threshold to 1.5 should fold it into the zero count.
+	h := &dto.Histogram{
+		Schema:        i32p(0),
+		SampleCount:   u64p(1),
+		SampleSum:     f64p(1),
+		ZeroThreshold: f64p(0),
+		ZeroCount:     u64p(0),
+		PositiveSpan:  []*dto.BucketSpan{{Offset: i32p(0), Length: u32p(1)}},
+		PositiveDelta: []int64{1},
+	}
+	withThreshold := &dto.Histogram{
+		Schema:        i32p(0),
+		SampleCount:   u64p(1),
+		SampleSum:     f64p(1),
+		ZeroThreshold: f64p(1.5),
+		ZeroCount:     u64p(1),
+	}
+
+	out, err := Merge([]*dto.Histogram{h, withThreshold}, 0)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if out.GetZeroThreshold() != 1.5 {
+		t.Errorf("merged zero threshold = %v, want 1.5", out.GetZeroThreshold())
+	}
+	if out.GetZeroCountFloat() != 2 {
+		t.Errorf("merged zero count = %v, want 2 (h's bucket 0 folded in + withThreshold's own zero count)", out.GetZeroCountFloat())
+	}
+	if len(out.PositiveSpan) != 0 {
+		t.Errorf("merged positive spans = %v, want none (folded into zero count)", out.PositiveSpan)
+	}
+}